@@ -22,4 +22,111 @@ func initDB() {
 			log.Fatal(err)
 		}
 	}
+	migrateMediaColumns()
+	migrateMediaFTSColumns()
+}
+
+// mediaColumnMigrations lists columns added to media after its initial
+// CREATE TABLE, in the order they were introduced. SQLite's ALTER TABLE ADD
+// COLUMN has no IF NOT EXISTS (and errors with "duplicate column name" if
+// just re-run), so migrateMediaColumns only runs the ones pragma
+// table_info(media) doesn't already report - making initDB safe to run
+// against a database created by any earlier version of this schema.
+var mediaColumnMigrations = []struct {
+	name string
+	ddl  string
+}{
+	{"sha256", "ALTER TABLE media ADD COLUMN sha256 TEXT"},
+	{"media_phash", "ALTER TABLE media ADD COLUMN media_phash INTEGER"},
+	{"blurhash", "ALTER TABLE media ADD COLUMN blurhash TEXT"},
+	{"taken_at", "ALTER TABLE media ADD COLUMN taken_at TIMESTAMP"},
+	{"camera_make", "ALTER TABLE media ADD COLUMN camera_make TEXT"},
+	{"camera_model", "ALTER TABLE media ADD COLUMN camera_model TEXT"},
+	{"gps_lat", "ALTER TABLE media ADD COLUMN gps_lat REAL"},
+	{"gps_lon", "ALTER TABLE media ADD COLUMN gps_lon REAL"},
+	{"orientation", "ALTER TABLE media ADD COLUMN orientation INTEGER"},
+	{"duration_seconds", "ALTER TABLE media ADD COLUMN duration_seconds REAL"},
+	{"video_codec", "ALTER TABLE media ADD COLUMN video_codec TEXT"},
+	{"audio_codec", "ALTER TABLE media ADD COLUMN audio_codec TEXT"},
+	{"fps", "ALTER TABLE media ADD COLUMN fps REAL"},
+	{"bitrate", "ALTER TABLE media ADD COLUMN bitrate INTEGER"},
+}
+
+// migrateMediaColumns adds any column in mediaColumnMigrations that media is
+// still missing, then (re)creates the indexes that depend on those columns.
+func migrateMediaColumns() {
+	existing, err := columnSet("media")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, m := range mediaColumnMigrations {
+		if existing[m.name] {
+			continue
+		}
+		if _, err := DB.Exec(m.ddl); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if _, err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_media_sha256 ON media(sha256)"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migrateMediaFTSColumns adds camera_model/taken_year to media_fts for the
+// "iphone 2023"-style search. media_fts is an fts5 virtual table, which
+// SQLite refuses to ADD COLUMN to ("virtual tables may not be altered"), so
+// unlike migrateMediaColumns this renames it aside, recreates it with the
+// full column set, reindexes every row from media and drops the old table.
+func migrateMediaFTSColumns() {
+	cols, err := columnSet("media_fts")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cols["camera_model"] {
+		return
+	}
+
+	if _, err := DB.Exec("ALTER TABLE media_fts RENAME TO media_fts_old"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("CREATE VIRTUAL TABLE media_fts USING fts5(ocr_text, tags, path, camera_model, taken_year)"); err != nil {
+		log.Fatal(err)
+	}
+	_, err = DB.Exec(`
+		INSERT INTO media_fts (rowid, ocr_text, tags, path, camera_model, taken_year)
+		SELECT id, ocr_text, tags, path, camera_model,
+			CASE WHEN taken_at IS NULL THEN '' ELSE strftime('%Y', taken_at) END
+		FROM media
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := DB.Exec("DROP TABLE media_fts_old"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// columnSet returns the set of column names a table currently has, via
+// pragma table_info - used to make ALTER TABLE ADD COLUMN migrations
+// idempotent since SQLite itself gives no way to express that.
+func columnSet(table string) (map[string]bool, error) {
+	rows, err := DB.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols := map[string]bool{}
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+	ffprobe "gopkg.in/vansante/go-ffprobe.v2"
+)
+
+// imageMetadata holds the EXIF fields we persist for a photo: when and on
+// what device it was taken, where, and how it needs rotating to display
+// upright.
+type imageMetadata struct {
+	takenAt     sql.NullString
+	cameraMake  sql.NullString
+	cameraModel sql.NullString
+	gpsLat      sql.NullFloat64
+	gpsLon      sql.NullFloat64
+	orientation sql.NullInt32
+}
+
+// extractEXIF reads EXIF tags from path. Most uploads (screenshots,
+// downloaded memes) carry no EXIF at all, so a decode failure just means an
+// empty imageMetadata rather than an error worth failing processing over.
+func extractEXIF(path string) imageMetadata {
+	var meta imageMetadata
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open %s for EXIF: %v", path, err)
+		return meta
+	}
+	defer f.Close()
+
+	x, err := exif.Decode(f)
+	if err != nil {
+		log.Printf("No EXIF data in %s: %v", path, err)
+		return meta
+	}
+
+	if tm, err := x.DateTime(); err == nil {
+		meta.takenAt = sql.NullString{String: tm.Format("2006-01-02 15:04:05"), Valid: true}
+	}
+	if tag, err := x.Get(exif.Make); err == nil {
+		if s, err := tag.StringVal(); err == nil {
+			meta.cameraMake = sql.NullString{String: s, Valid: true}
+		}
+	}
+	if tag, err := x.Get(exif.Model); err == nil {
+		if s, err := tag.StringVal(); err == nil {
+			meta.cameraModel = sql.NullString{String: s, Valid: true}
+		}
+	}
+	if lat, lon, err := x.LatLong(); err == nil {
+		meta.gpsLat = sql.NullFloat64{Float64: lat, Valid: true}
+		meta.gpsLon = sql.NullFloat64{Float64: lon, Valid: true}
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if v, err := tag.Int(0); err == nil {
+			meta.orientation = sql.NullInt32{Int32: int32(v), Valid: true}
+		}
+	}
+
+	return meta
+}
+
+// takenYear returns the year component of meta.takenAt for the "iphone
+// 2023"-style FTS search, or "" if there's no taken_at to derive it from.
+func (meta imageMetadata) takenYear() string {
+	if !meta.takenAt.Valid {
+		return ""
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", meta.takenAt.String)
+	if err != nil {
+		return ""
+	}
+	return strconv.Itoa(t.Year())
+}
+
+// applyOrientation rotates/flips img per the EXIF orientation tag so the
+// thumbnail, phash and embedding are all computed from an upright image.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
+}
+
+// videoMetadata holds the ffprobe-derived fields we persist for a video or
+// animated gif: its real dimensions (the extracted thumbnail frame is not a
+// reliable source for these), timing, and codecs.
+type videoMetadata struct {
+	durationSeconds sql.NullFloat64
+	videoCodec      sql.NullString
+	audioCodec      sql.NullString
+	fps             sql.NullFloat64
+	bitrate         sql.NullInt64
+	width           sql.NullInt32
+	height          sql.NullInt32
+}
+
+// extractVideoMetadata shells out to ffprobe (via go-ffprobe.v2, which wraps
+// `ffprobe -v quiet -print_format json -show_streams -show_format`) to read
+// a video's real metadata, which the single extracted frame can't tell us.
+func extractVideoMetadata(path string) videoMetadata {
+	var meta videoMetadata
+
+	data, err := ffprobe.ProbeURL(context.Background(), path)
+	if err != nil {
+		log.Printf("ffprobe failed for %s: %v", path, err)
+		return meta
+	}
+
+	if data.Format != nil {
+		meta.durationSeconds = sql.NullFloat64{Float64: data.Format.Duration().Seconds(), Valid: true}
+		if bitrate, err := strconv.ParseInt(data.Format.BitRate, 10, 64); err == nil {
+			meta.bitrate = sql.NullInt64{Int64: bitrate, Valid: true}
+		}
+	}
+
+	if vs := data.FirstVideoStream(); vs != nil {
+		meta.videoCodec = sql.NullString{String: vs.CodecName, Valid: true}
+		meta.width = sql.NullInt32{Int32: int32(vs.Width), Valid: true}
+		meta.height = sql.NullInt32{Int32: int32(vs.Height), Valid: true}
+		if fps, err := parseFrameRate(vs.AvgFrameRate); err == nil {
+			meta.fps = sql.NullFloat64{Float64: fps, Valid: true}
+		}
+	}
+	if as := data.FirstAudioStream(); as != nil {
+		meta.audioCodec = sql.NullString{String: as.CodecName, Valid: true}
+	}
+
+	return meta
+}
+
+// parseFrameRate parses ffprobe's "num/den" frame rate strings (e.g. "30/1",
+// "24000/1001") into fps.
+func parseFrameRate(s string) (float64, error) {
+	num, den, found := strings.Cut(s, "/")
+	if !found {
+		return strconv.ParseFloat(s, 64)
+	}
+	numVal, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, err
+	}
+	denVal, err := strconv.ParseFloat(den, 64)
+	if err != nil || denVal == 0 {
+		return 0, fmt.Errorf("invalid frame rate %q", s)
+	}
+	return numVal / denVal, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobKindProcessMedia is the only job kind today: run processMedia for a
+// media row.
+const jobKindProcessMedia = "process_media"
+
+const (
+	jobPollInterval      = time.Second
+	jobClaimBatch        = 5
+	jobMaxAttempts       = 6
+	jobBaseBackoff       = 60 * time.Second
+	jobMaxBackoff        = time.Hour
+	jobHeartbeatInterval = 15 * time.Second
+	jobStaleAfter        = 2 * time.Minute
+)
+
+// Job is a claimed row from the jobs table.
+type Job struct {
+	ID       int64
+	MediaID  int64
+	Kind     string
+	Attempts int
+}
+
+// enqueueJob inserts a pending job for mediaID, to be picked up by the worker pool.
+func enqueueJob(mediaID int64, kind string) error {
+	_, err := DB.Exec("INSERT INTO jobs (media_id, kind, status, next_run_at) VALUES (?, ?, 'pending', CURRENT_TIMESTAMP)", mediaID, kind)
+	return err
+}
+
+// startJobWorkers starts numWorkers goroutines that poll the jobs table for
+// due work. Call requeueOrphanedJobs once at startup before this.
+func startJobWorkers(numWorkers int) {
+	for i := range numWorkers {
+		go func(workerID int) {
+			log.Printf("Starting job worker %d", workerID)
+			for {
+				jobs, err := claimJobs(jobClaimBatch)
+				if err != nil {
+					log.Printf("Worker %d failed to claim jobs: %v", workerID, err)
+					time.Sleep(jobPollInterval)
+					continue
+				}
+				if len(jobs) == 0 {
+					time.Sleep(jobPollInterval)
+					continue
+				}
+				for _, job := range jobs {
+					runJob(workerID, job)
+				}
+			}
+		}(i)
+	}
+}
+
+// claimJobs atomically marks up to n pending, due jobs as in_progress and
+// returns them, so concurrent workers (and replicas) never double-process
+// the same job.
+func claimJobs(n int) ([]Job, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE jobs
+		SET status = 'in_progress', heartbeat_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE status = 'pending' AND next_run_at <= CURRENT_TIMESTAMP
+			ORDER BY next_run_at
+			LIMIT ?
+		)
+		RETURNING id, media_id, kind, attempts
+	`, n)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.ID, &j.MediaID, &j.Kind, &j.Attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// runJob executes one job, keeping its heartbeat fresh while it runs, and
+// marks it completed or reschedules/fails it based on the outcome.
+func runJob(workerID int, job Job) {
+	log.Printf("Worker %d running job %d (media %d, kind %s, attempt %d)", workerID, job.ID, job.MediaID, job.Kind, job.Attempts+1)
+
+	stopHeartbeat := startJobHeartbeat(job.ID)
+	defer stopHeartbeat()
+
+	var path, mimeType string
+	if err := DB.QueryRow("SELECT path, mime FROM media WHERE id = ?", job.MediaID).Scan(&path, &mimeType); err != nil {
+		failJob(job, fmt.Errorf("loading media %d: %w", job.MediaID, err))
+		return
+	}
+
+	if err := processMedia(job.MediaID, path, mimeType); err != nil {
+		failJob(job, err)
+		return
+	}
+
+	if _, err := DB.Exec("UPDATE jobs SET status = 'completed' WHERE id = ?", job.ID); err != nil {
+		log.Printf("Failed to mark job %d completed: %v", job.ID, err)
+	}
+}
+
+// startJobHeartbeat periodically bumps heartbeat_at while a job runs, so
+// requeueStaleJobs can tell a genuinely stuck job from one that's just slow.
+// The returned func stops the ticker.
+func startJobHeartbeat(jobID int64) func() {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := DB.Exec("UPDATE jobs SET heartbeat_at = CURRENT_TIMESTAMP WHERE id = ?", jobID); err != nil {
+					log.Printf("Failed to heartbeat job %d: %v", jobID, err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// failJob increments attempts and reschedules with exponential backoff
+// (min(jobBaseBackoff * 2^(attempts-1), jobMaxBackoff), counting the attempt
+// that just failed, so the first retry waits jobBaseBackoff), or marks the job
+// permanently failed once jobMaxAttempts is reached.
+func failJob(job Job, cause error) {
+	attempts := job.Attempts + 1
+	log.Printf("Job %d failed (attempt %d/%d): %v", job.ID, attempts, jobMaxAttempts, cause)
+
+	if attempts >= jobMaxAttempts {
+		_, err := DB.Exec("UPDATE jobs SET status = 'failed', attempts = ?, last_error = ? WHERE id = ?", attempts, cause.Error(), job.ID)
+		if err != nil {
+			log.Printf("Failed to mark job %d failed: %v", job.ID, err)
+		}
+		return
+	}
+
+	backoff := jobBaseBackoff * time.Duration(1<<uint(attempts-1))
+	if backoff > jobMaxBackoff {
+		backoff = jobMaxBackoff
+	}
+	_, err := DB.Exec(`
+		UPDATE jobs
+		SET status = 'pending', attempts = ?, last_error = ?, next_run_at = datetime(CURRENT_TIMESTAMP, ?)
+		WHERE id = ?
+	`, attempts, cause.Error(), fmt.Sprintf("+%d seconds", int(backoff.Seconds())), job.ID)
+	if err != nil {
+		log.Printf("Failed to reschedule job %d: %v", job.ID, err)
+	}
+}
+
+// requeueOrphanedJobs resets every in_progress job back to pending,
+// unconditionally. Call once at startup, before workers start claiming: a
+// freshly started process can't own any in_progress row, so every one of
+// them was left behind by a previous process that crashed or restarted, no
+// matter how fresh its heartbeat looks.
+func requeueOrphanedJobs() error {
+	res, err := DB.Exec(`UPDATE jobs SET status = 'pending' WHERE status = 'in_progress'`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Requeued %d job(s) orphaned by a previous run", n)
+	}
+	return nil
+}
+
+// startDeadJobReaper runs requeueStaleJobs on a jobStaleAfter ticker for the
+// life of the process, so a worker that dies mid-run (not just one
+// orphaned by a restart) is also recovered instead of leaving its job stuck
+// in_progress forever.
+func startDeadJobReaper() {
+	go func() {
+		ticker := time.NewTicker(jobStaleAfter)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := requeueStaleJobs(); err != nil {
+				log.Printf("Dead job reaper failed: %v", err)
+			}
+		}
+	}()
+}
+
+// requeueStaleJobs resets any in_progress job whose heartbeat has gone
+// stale (no update in jobStaleAfter) back to pending so another worker
+// picks it up.
+func requeueStaleJobs() error {
+	res, err := DB.Exec(`
+		UPDATE jobs
+		SET status = 'pending'
+		WHERE status = 'in_progress' AND heartbeat_at <= datetime(CURRENT_TIMESTAMP, ?)
+	`, fmt.Sprintf("-%d seconds", int(jobStaleAfter.Seconds())))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Requeued %d stale job(s)", n)
+	}
+	return nil
+}
+
+// adminJobsHandler reports job counts by status for observability.
+func adminJobsHandler(c *gin.Context) {
+	rows, err := DB.Query("SELECT status, COUNT(*) FROM jobs GROUP BY status")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		counts[status] = count
+	}
+	c.JSON(http.StatusOK, counts)
+}
@@ -2,47 +2,119 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
 	"log"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/buckket/go-blurhash"
 	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
-// ProcessingJob represents a media processing task
-type ProcessingJob struct {
-	ID       int64
-	Path     string
-	MimeType string
+// completionChans lets getMediaStatusHandler long-poll for a specific
+// media id's processing to finish instead of busy-polling. A channel is
+// created when the job is queued and closed (then removed) in
+// processMedia's defer once processing completes.
+var (
+	completionMu    sync.Mutex
+	completionChans = make(map[int64]chan struct{})
+)
+
+// registerCompletion creates (or returns the existing) completion channel for id.
+func registerCompletion(id int64) chan struct{} {
+	completionMu.Lock()
+	defer completionMu.Unlock()
+	ch, ok := completionChans[id]
+	if !ok {
+		ch = make(chan struct{})
+		completionChans[id] = ch
+	}
+	return ch
 }
 
-// Job queue channel
-var jobQueue = make(chan ProcessingJob, 100)
+// signalCompletion wakes up any waiters for id.
+func signalCompletion(id int64) {
+	completionMu.Lock()
+	defer completionMu.Unlock()
+	if ch, ok := completionChans[id]; ok {
+		close(ch)
+		delete(completionChans, id)
+	}
+}
+
+// waitForCompletion blocks until id's processing finishes or timeout elapses,
+// returning immediately if it has already finished.
+func waitForCompletion(id int64, timeout time.Duration) {
+	// Register before reading the status, so a finish between the two still
+	// closes the channel this waits on.
+	ch := registerCompletion(id)
+	status, err := getProcessingStatus(id)
+	if err != nil || status != "processing" {
+		signalCompletion(id)
+		return
+	}
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+	}
+}
+
+func getProcessingStatus(id int64) (string, error) {
+	var status string
+	err := DB.QueryRow("SELECT processing_status FROM media WHERE id = ?", id).Scan(&status)
+	return status, err
+}
 
 func main() {
 	initDB()
+	initStorage()
+
 	r := gin.Default()
-	r.Static("/static", "./static")
-	r.Static("/storage", "./storage")
+	if _, local := store.(*LocalFS); local {
+		r.Static("/static", "./static")
+		r.Static("/storage", "./storage")
+	}
 
 	r.LoadHTMLGlob("templates/*")
 
 	r.GET("/", indexHandler)
 	r.GET("/search", searchHandler)
+	r.GET("/search/semantic", semanticSearchHandler)
 	r.POST("/upload", uploadHandler)
+	r.POST("/upload/async", uploadAsyncHandler)
 	r.PUT("/media/:id/tags", updateTagsHandler)
 	r.GET("/media/:id/status", getMediaStatusHandler)
+	r.GET("/media/:id/similar", similarMediaHandler)
+	r.GET("/admin/jobs", adminJobsHandler)
+
+	// Requeue anything orphaned by a previous crash before workers start
+	// claiming, then start the durable job queue's worker pool and a
+	// periodic reaper for workers that die mid-run during normal operation.
+	if err := requeueOrphanedJobs(); err != nil {
+		log.Printf("Failed to requeue orphaned jobs: %v", err)
+	}
+	go startJobWorkers(3)
+	startDeadJobReaper()
 
-	// Start background processing workers
-	go startProcessingWorkers(3)
+	// Load the semantic search index in the background so startup isn't
+	// blocked on scanning media_embeddings.
+	go loadEmbeddingIndex()
 
 	log.Println("listening on http://localhost:8080")
 	if err := r.Run(":8080"); err != nil {
@@ -50,19 +122,6 @@ func main() {
 	}
 }
 
-// startProcessingWorkers starts the specified number of background workers
-func startProcessingWorkers(numWorkers int) {
-	for i := range numWorkers {
-		go func(workerID int) {
-			log.Printf("Starting processing worker %d", workerID)
-			for job := range jobQueue {
-				log.Printf("Worker %d processing job for media ID %d", workerID, job.ID)
-				processMedia(job.ID, job.Path, job.MimeType)
-			}
-		}(i)
-	}
-}
-
 func uploadHandler(c *gin.Context) {
 	form, err := c.MultipartForm()
 	if err != nil {
@@ -77,73 +136,187 @@ func uploadHandler(c *gin.Context) {
 		return
 	}
 
-	allowedTypes := map[string]bool{
-		"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true,
-		"video/mp4": true, "video/webm": true,
-	}
-	const maxSize = 10 << 20 // 10MB
-
 	for _, file := range files {
-		mimeType := mime.TypeByExtension(filepath.Ext(file.Filename))
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
-		}
-		if !allowedTypes[mimeType] {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported file type: " + mimeType})
-			return
-		}
-		if file.Size > maxSize {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File too large (max 10MB)"})
+		if err := validateUpload(file); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 	}
 
+	results := make([]gin.H, 0, len(files))
 	for _, file := range files {
-		ext := filepath.Ext(file.Filename)
-		path := "storage/" + uuid.New().String() + ext
-		if err := c.SaveUploadedFile(file, path); err != nil {
+		id, duplicate, err := saveAndQueueUpload(file, tags)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		results = append(results, gin.H{"id": id, "duplicate": duplicate})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "uploaded", "results": results})
+}
 
-		mimeType := mime.TypeByExtension(ext)
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
-		}
+// uploadAsyncHandler is the MSC2246-style async upload API: it saves and
+// queues the first uploaded file, then returns immediately with the new
+// media id and the status URL to poll (optionally with long-polling, see
+// getMediaStatusHandler).
+func uploadAsyncHandler(c *gin.Context) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	files := form.File["files"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files selected"})
+		return
+	}
+	tags := c.PostForm("tags")
 
-		// Get file size
-		fileInfo, err := os.Stat(path)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		sizeBytes := fileInfo.Size()
+	if err := validateUpload(files[0]); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		res, err := DB.Exec("INSERT INTO media (path, mime, size_bytes, tags, processing_status) VALUES (?, ?, ?, ?, ?)", path, mimeType, sizeBytes, tags, "processing")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		id, err := res.LastInsertId()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+	id, duplicate, err := saveAndQueueUpload(files[0], tags)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-		// Insert into FTS
-		_, err = DB.Exec("INSERT INTO media_fts (rowid, ocr_text, tags, path) VALUES (?, ?, ?, ?)", id, "", tags, path)
-		if err != nil {
-			log.Printf("Failed to insert FTS: %v", err)
-		}
+	statusURL := "/media/" + strconv.FormatInt(id, 10) + "/status"
+	c.JSON(http.StatusOK, gin.H{"id": id, "status_url": statusURL, "duplicate": duplicate})
+}
 
-		// Queue media processing for background execution
-		jobQueue <- ProcessingJob{
-			ID:       id,
-			Path:     path,
-			MimeType: mimeType,
-		}
+var allowedUploadTypes = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true,
+	"video/mp4": true, "video/webm": true,
+}
+
+const maxUploadSize = 10 << 20 // 10MB
+
+// validateUpload checks a single multipart file against the allowed mime
+// types and max size before it's saved.
+func validateUpload(file *multipart.FileHeader) error {
+	mimeType := mime.TypeByExtension(filepath.Ext(file.Filename))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	if !allowedUploadTypes[mimeType] {
+		return fmt.Errorf("Unsupported file type: %s", mimeType)
+	}
+	if file.Size > maxUploadSize {
+		return fmt.Errorf("File too large (max 10MB)")
+	}
+	return nil
+}
+
+// saveAndQueueUpload streams a single uploaded file through sha256 into a
+// temp file (so size is enforced as bytes are written), then moves it to its
+// content-addressed path and persists it. If a media row with the same
+// sha256 already exists, the temp file is discarded and the existing id is
+// returned with duplicate=true instead of creating a new row. It's shared by
+// the synchronous and async upload handlers.
+func saveAndQueueUpload(file *multipart.FileHeader, tags string) (id int64, duplicate bool, err error) {
+	ext := filepath.Ext(file.Filename)
+	sum, tmpPath, sizeBytes, err := hashUploadToTemp(file)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var existingID int64
+	err = DB.QueryRow("SELECT id FROM media WHERE sha256 = ?", sum).Scan(&existingID)
+	if err == nil {
+		os.Remove(tmpPath)
+		return existingID, true, nil
+	}
+	if err != sql.ErrNoRows {
+		os.Remove(tmpPath)
+		return 0, false, err
+	}
+
+	path := "storage/" + sum[:2] + "/" + sum + ext
+	if err := putTempFile(path, tmpPath); err != nil {
+		return 0, false, err
+	}
+
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "uploaded"})
+
+	res, err := DB.Exec("INSERT INTO media (path, mime, size_bytes, tags, sha256, processing_status) VALUES (?, ?, ?, ?, ?, ?)", path, mimeType, sizeBytes, tags, sum, "processing")
+	if err != nil {
+		return 0, false, err
+	}
+	id, err = res.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Insert into FTS
+	_, err = DB.Exec("INSERT INTO media_fts (rowid, ocr_text, tags, path) VALUES (?, ?, ?, ?)", id, "", tags, path)
+	if err != nil {
+		log.Printf("Failed to insert FTS: %v", err)
+	}
+
+	// Register the completion channel before queuing so a waiter in
+	// getMediaStatusHandler can never race the worker finishing first.
+	registerCompletion(id)
+
+	// Queue media processing as a durable job: it survives a crash or
+	// restart, unlike the old in-memory channel.
+	if err := enqueueJob(id, jobKindProcessMedia); err != nil {
+		return 0, false, err
+	}
+	return id, false, nil
+}
+
+// hashUploadToTemp streams an uploaded file into a local OS temp file while
+// hashing it, enforcing maxUploadSize as bytes are written rather than
+// trusting the declared Content-Length. Returns the hex sha256 digest, the
+// temp file path (caller moves or removes it) and the size in bytes. It
+// always uses the local disk, even with the S3 backend, since the content
+// hash has to be known before the final Storage key can be chosen.
+func hashUploadToTemp(file *multipart.FileHeader) (sum, tmpPath string, size int64, err error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "meme-vault-upload-*.tmp")
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(src, maxUploadSize+1))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", 0, err
+	}
+	if written > maxUploadSize {
+		os.Remove(tmp.Name())
+		return "", "", 0, fmt.Errorf("File too large (max 10MB)")
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), tmp.Name(), written, nil
+}
+
+// putTempFile uploads a local temp file to the Storage backend under key and
+// removes the temp file afterward, regardless of outcome.
+func putTempFile(key, tmpPath string) error {
+	defer os.Remove(tmpPath)
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = store.Put(context.Background(), key, f)
+	return err
 }
 
 type Media struct {
@@ -158,6 +331,7 @@ type Media struct {
 	OcrText          sql.NullString
 	ProcessingStatus string
 	CreatedAt        string
+	Blurhash         sql.NullString
 }
 
 func indexHandler(c *gin.Context) {
@@ -183,46 +357,85 @@ func searchHandler(c *gin.Context) {
 }
 
 func getMedia(query string) ([]Media, error) {
-	var rows *sql.Rows
-	var err error
 	if query == "" {
-		rows, err = DB.Query("SELECT id, path, thumb, mime, width, height, size_bytes, tags, ocr_text, processing_status, created_at FROM media ORDER BY created_at DESC")
-	} else {
-		log.Printf("FTS query: %s", query)
-		ftsRows, err := DB.Query("SELECT rowid FROM media_fts WHERE media_fts MATCH ?", query)
+		rows, err := DB.Query("SELECT id, path, thumb, mime, width, height, size_bytes, tags, ocr_text, processing_status, created_at, blurhash FROM media ORDER BY created_at DESC")
 		if err != nil {
-			log.Printf("FTS query error: %v", err)
 			return nil, err
 		}
-		var ids []int64
-		for ftsRows.Next() {
-			var id int64
-			ftsRows.Scan(&id)
-			ids = append(ids, id)
-		}
-		ftsRows.Close()
-		log.Printf("Matching ids: %v", ids)
-		if len(ids) == 0 {
-			return []Media{}, nil
-		}
-		placeholders := strings.Repeat("?,", len(ids))
-		placeholders = placeholders[:len(placeholders)-1] // remove last comma
-		rows, err = DB.Query(`
-			SELECT id, path, thumb, mime, width, height, size_bytes, tags, ocr_text, processing_status, created_at
-			FROM media
-			WHERE id IN (`+placeholders+`)
-			ORDER BY created_at DESC
-		`, idsToInterface(ids)...)
+		defer rows.Close()
+		return scanMediaRows(rows)
+	}
+
+	log.Printf("FTS query: %s", query)
+	ftsRows, err := DB.Query("SELECT rowid FROM media_fts WHERE media_fts MATCH ? ORDER BY rank", query)
+	if err != nil {
+		log.Printf("FTS query error: %v", err)
+		return nil, err
+	}
+	var lexicalIDs []int64
+	for ftsRows.Next() {
+		var id int64
+		ftsRows.Scan(&id)
+		lexicalIDs = append(lexicalIDs, id)
+	}
+	ftsRows.Close()
+	log.Printf("Matching ids: %v", lexicalIDs)
+
+	// Fuse with semantic hits (reciprocal rank fusion, both lists weighted
+	// equally), falling back
+	// to lexical-only if the query can't be encoded.
+	var semanticIDs []int64
+	if queryVec, err := encodeTextEmbedding(query); err != nil {
+		log.Printf("Semantic encode failed for %q, falling back to lexical search: %v", query, err)
+	} else {
+		semanticIDs = embeddingIndex.TopK(queryVec, 50)
 	}
+
+	fusedIDs := reciprocalRankFusion(lexicalIDs, semanticIDs)
+	return getMediaByIDs(fusedIDs)
+}
+
+// getMediaByIDs fetches media rows for the given ids, preserving the order of ids.
+func getMediaByIDs(ids []int64) ([]Media, error) {
+	if len(ids) == 0 {
+		return []Media{}, nil
+	}
+
+	placeholders := strings.Repeat("?,", len(ids))
+	placeholders = placeholders[:len(placeholders)-1] // remove last comma
+	rows, err := DB.Query(`
+		SELECT id, path, thumb, mime, width, height, size_bytes, tags, ocr_text, processing_status, created_at, blurhash
+		FROM media
+		WHERE id IN (`+placeholders+`)
+	`, idsToInterface(ids)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	byID := make(map[int64]Media, len(ids))
+	for rows.Next() {
+		m, err := scanMediaRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[m.ID] = m
+	}
+
+	media := make([]Media, 0, len(ids))
+	for _, id := range ids {
+		if m, ok := byID[id]; ok {
+			media = append(media, m)
+		}
+	}
+	log.Printf("Returning %d media items", len(media))
+	return media, nil
+}
+
+func scanMediaRows(rows *sql.Rows) ([]Media, error) {
 	var media []Media
 	for rows.Next() {
-		var m Media
-		err := rows.Scan(&m.ID, &m.Path, &m.Thumb, &m.Mime, &m.Width, &m.Height, &m.SizeBytes, &m.Tags, &m.OcrText, &m.ProcessingStatus, &m.CreatedAt)
+		m, err := scanMediaRow(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -232,6 +445,12 @@ func getMedia(query string) ([]Media, error) {
 	return media, nil
 }
 
+func scanMediaRow(rows *sql.Rows) (Media, error) {
+	var m Media
+	err := rows.Scan(&m.ID, &m.Path, &m.Thumb, &m.Mime, &m.Width, &m.Height, &m.SizeBytes, &m.Tags, &m.OcrText, &m.ProcessingStatus, &m.CreatedAt, &m.Blurhash)
+	return m, err
+}
+
 func idsToInterface(ids []int64) []interface{} {
 	result := make([]interface{}, len(ids))
 	for i, id := range ids {
@@ -271,56 +490,193 @@ func getMediaStatusHandler(c *gin.Context) {
 		return
 	}
 
+	if maxStallMs, err := strconv.Atoi(c.Query("max_stall_ms")); err == nil && maxStallMs > 0 {
+		waitForCompletion(id, time.Duration(maxStallMs)*time.Millisecond)
+	}
+
 	var media Media
-	err = DB.QueryRow("SELECT id, path, thumb, mime, width, height, size_bytes, tags, ocr_text, processing_status, created_at FROM media WHERE id = ?", id).Scan(
-		&media.ID, &media.Path, &media.Thumb, &media.Mime, &media.Width, &media.Height, &media.SizeBytes, &media.Tags, &media.OcrText, &media.ProcessingStatus, &media.CreatedAt)
+	err = DB.QueryRow("SELECT id, path, thumb, mime, width, height, size_bytes, tags, ocr_text, processing_status, created_at, blurhash FROM media WHERE id = ?", id).Scan(
+		&media.ID, &media.Path, &media.Thumb, &media.Mime, &media.Width, &media.Height, &media.SizeBytes, &media.Tags, &media.OcrText, &media.ProcessingStatus, &media.CreatedAt, &media.Blurhash)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
 		return
 	}
 
+	if media.ProcessingStatus == "processing" {
+		// Either no max_stall_ms was given, or we waited the full timeout
+		// without the job finishing.
+		c.Header("Retry-After", "1")
+	}
+
 	// Check if this is an HTMX request
 	if c.GetHeader("HX-Request") == "true" {
 		// Return HTML fragment for HTMX
 		c.HTML(http.StatusOK, "grid", []Media{media})
 	} else {
 		// Return JSON for API calls
+		var thumbURL string
+		if media.Thumb.Valid {
+			if url, err := store.SignedURL(c.Request.Context(), media.Thumb.String); err != nil {
+				log.Printf("Failed to sign thumb URL for %d: %v", media.ID, err)
+			} else {
+				thumbURL = url
+			}
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"id":                media.ID,
 			"processing_status": media.ProcessingStatus,
-			"thumb_url":         media.Thumb.String,
+			"thumb_url":         thumbURL,
 			"ocr_text":          media.OcrText.String,
+			"blurhash":          media.Blurhash.String,
 		})
 	}
 }
 
-func processMedia(id int64, path, mimeType string) {
+// similarMediaHandler returns media ordered by Hamming distance of their
+// perceptual hash to id's, closest first. Items without a phash yet (still
+// processing, or not an image/video) are excluded.
+func similarMediaHandler(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	var phash sql.NullInt64
+	if err := DB.QueryRow("SELECT media_phash FROM media WHERE id = ?", id).Scan(&phash); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+	if !phash.Valid {
+		c.JSON(http.StatusOK, []Media{})
+		return
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, path, thumb, mime, width, height, size_bytes, tags, ocr_text, processing_status, created_at, blurhash, media_phash
+		FROM media
+		WHERE id != ? AND media_phash IS NOT NULL
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		media    Media
+		distance int
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var m Media
+		var p int64
+		if err := rows.Scan(&m.ID, &m.Path, &m.Thumb, &m.Mime, &m.Width, &m.Height, &m.SizeBytes, &m.Tags, &m.OcrText, &m.ProcessingStatus, &m.CreatedAt, &m.Blurhash, &p); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		candidates = append(candidates, candidate{m, hammingDistance64(uint64(phash.Int64), uint64(p))})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	media := make([]Media, len(candidates))
+	for i, cand := range candidates {
+		media[i] = cand.media
+	}
+	c.JSON(http.StatusOK, media)
+}
+
+// processMedia runs the processing pipeline for one media item. It returns
+// an error so the job queue can retry with backoff on failure.
+func processMedia(id int64, path, mimeType string) error {
 	log.Printf("Starting media processing for ID %d, path: %s, type: %s", id, path, mimeType)
-	defer log.Printf("Completed media processing for ID %d", id)
+	defer func() {
+		log.Printf("Completed media processing for ID %d", id)
+		signalCompletion(id)
+	}()
+
+	// ffmpeg/tesseract need a real local file; with the S3 backend that
+	// means pulling the object down first.
+	localPath, cleanup, err := materializeLocal(path)
+	if err != nil {
+		log.Printf("Failed to materialize %s for processing: %v", path, err)
+		return err
+	}
+	defer cleanup()
 
 	if strings.HasPrefix(mimeType, "image/") {
-		processImage(id, path)
+		return processImage(id, localPath)
 	} else if strings.HasPrefix(mimeType, "video/") || mimeType == "image/gif" {
-		processVideoOrGif(id, path)
-	} else {
-		log.Printf("Unsupported media type for processing: %s", mimeType)
+		return processVideoOrGif(id, localPath)
 	}
+	log.Printf("Unsupported media type for processing: %s", mimeType)
+	return fmt.Errorf("unsupported media type: %s", mimeType)
 }
 
-func processImage(id int64, path string) {
-	src, err := imaging.Open(path)
+// materializeLocal ensures key is available at a local path that exec-based
+// tools (ffmpeg, tesseract) can open directly. With LocalFS it's already on
+// disk, so this is a no-op; with a remote backend it downloads to a temp
+// file that the returned cleanup func removes.
+func materializeLocal(key string) (localPath string, cleanup func(), err error) {
+	if lfs, ok := store.(*LocalFS); ok {
+		return filepath.Join(lfs.baseDir, key), func() {}, nil
+	}
+
+	rc, err := store.Get(context.Background(), key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "meme-vault-proc-*"+filepath.Ext(key))
+	if err != nil {
+		return "", nil, err
+	}
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, rc); err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
+
+func processImage(id int64, path string) error {
+	opened, err := imaging.Open(path)
 	if err != nil {
 		log.Printf("Failed to open image %s: %v", path, err)
-		return
+		return err
 	}
 
-	// Generate thumbnail
-	thumb := imaging.Resize(src, 200, 0, imaging.Lanczos)
-	thumbPath := "static/" + strconv.FormatInt(id, 10) + "_thumb.jpg"
-	err = imaging.Save(thumb, thumbPath)
+	// EXIF orientation is applied before everything downstream (thumbnail,
+	// phash, embedding) so they're all computed from an upright image.
+	exifMeta := extractEXIF(path)
+	var src image.Image = opened
+	if exifMeta.orientation.Valid {
+		src = applyOrientation(src, int(exifMeta.orientation.Int32))
+	}
+
+	// Blurhash placeholder for progressive loading, decoded client-side while
+	// the real thumbnail loads.
+	hash, err := blurhash.Encode(4, 3, src)
 	if err != nil {
-		log.Printf("Failed to save thumbnail %s: %v", thumbPath, err)
-		return
+		log.Printf("Failed to compute blurhash for %s: %v", path, err)
+	}
+
+	// Generate thumbnail and store it through the Storage backend; thumb
+	// holds the storage key (as media.path does), not a guaranteed-local path.
+	thumb := imaging.Resize(src, 200, 0, imaging.Lanczos)
+	thumbKey := "static/" + strconv.FormatInt(id, 10) + "_thumb.jpg"
+	var thumbBuf bytes.Buffer
+	if err := imaging.Encode(&thumbBuf, thumb, imaging.JPEG); err != nil {
+		log.Printf("Failed to encode thumbnail for %d: %v", id, err)
+		return err
+	}
+	if _, err := store.Put(context.Background(), thumbKey, bytes.NewReader(thumbBuf.Bytes())); err != nil {
+		log.Printf("Failed to save thumbnail %s: %v", thumbKey, err)
+		return err
 	}
 
 	// Get dimensions
@@ -331,16 +687,47 @@ func processImage(id int64, path string) {
 	// OCR text extraction
 	ocrText := extractOCR(path)
 
+	// Semantic embedding for /search/semantic, computed from the thumbnail
+	// since CLIP models downsample to ~224px anyway. The sidecar needs a
+	// local file, so materialize one from the already-encoded thumbnail
+	// bytes rather than re-fetching from storage.
+	thumbLocalPath, cleanupThumb, err := writeTempFile(thumbBuf.Bytes(), ".jpg")
+	if err != nil {
+		log.Printf("Failed to stage thumbnail for embedding %d: %v", id, err)
+	} else {
+		defer cleanupThumb()
+		if vec, err := encodeImageEmbedding(thumbLocalPath); err != nil {
+			log.Printf("Failed to compute embedding for %s: %v", path, err)
+		} else {
+			storeEmbedding(id, vec)
+		}
+	}
+
+	// Perceptual hash for /media/:id/similar, stored as a signed int64 (pHash
+	// is naturally a uint64; SQLite INTEGER is a signed 64-bit column, so it
+	// round-trips fine as long as both sides use the same bit pattern).
+	phash := int64(computePHash(src))
+
 	// Update DB
-	_, err = DB.Exec("UPDATE media SET thumb = ?, width = ?, height = ?, ocr_text = ?, processing_status = 'completed' WHERE id = ?", thumbPath, width, height, ocrText, id)
+	_, err = DB.Exec(`
+		UPDATE media
+		SET thumb = ?, width = ?, height = ?, ocr_text = ?, media_phash = ?, blurhash = ?,
+			taken_at = ?, camera_make = ?, camera_model = ?, gps_lat = ?, gps_lon = ?, orientation = ?,
+			processing_status = 'completed'
+		WHERE id = ?
+	`, thumbKey, width, height, ocrText, phash, hash,
+		exifMeta.takenAt, exifMeta.cameraMake, exifMeta.cameraModel, exifMeta.gpsLat, exifMeta.gpsLon, exifMeta.orientation,
+		id)
 	if err != nil {
 		log.Printf("Failed to update DB for %s: %v", id, err)
+		return err
 	}
 	// Update FTS
-	_, err = DB.Exec("UPDATE media_fts SET ocr_text = ? WHERE id = ?", ocrText, id)
+	_, err = DB.Exec("UPDATE media_fts SET ocr_text = ?, camera_model = ?, taken_year = ? WHERE rowid = ?", ocrText, exifMeta.cameraModel, exifMeta.takenYear(), id)
 	if err != nil {
 		log.Printf("Failed to update FTS for %s: %v", id, err)
 	}
+	return nil
 }
 
 func extractOCR(imagePath string) string {
@@ -355,19 +742,36 @@ func extractOCR(imagePath string) string {
 	return strings.TrimSpace(out.String())
 }
 
-func processVideoOrGif(id int64, path string) {
-	// Extract first frame
-	framePath := "static/" + strconv.FormatInt(id, 10) + "_frame.jpg"
+func processVideoOrGif(id int64, path string) error {
+	// Extract first frame into a local scratch file (never itself persisted
+	// through Storage; processImage stores the thumbnail it derives from it).
+	framePath := filepath.Join(os.TempDir(), "meme-vault-frame-"+strconv.FormatInt(id, 10)+".jpg")
 	cmd := exec.Command("ffmpeg", "-i", path, "-vframes", "1", "-q:v", "2", framePath)
 	err := cmd.Run()
 	if err != nil {
 		log.Printf("Failed to extract frame for %s: %v", path, err)
-		return
+		return err
 	}
+	defer os.Remove(framePath)
 
 	// Process as image
-	processImage(id, framePath)
+	if err := processImage(id, framePath); err != nil {
+		return err
+	}
 
-	// Clean up frame file
-	os.Remove(framePath)
+	// The extracted frame only tells processImage a thumbnail/OCR/phash; the
+	// real dimensions, duration and codecs come from ffprobe against the
+	// original video, and override the frame's (possibly different) size.
+	videoMeta := extractVideoMetadata(path)
+	_, err = DB.Exec(`
+		UPDATE media
+		SET duration_seconds = ?, video_codec = ?, audio_codec = ?, fps = ?, bitrate = ?,
+			width = COALESCE(?, width), height = COALESCE(?, height)
+		WHERE id = ?
+	`, videoMeta.durationSeconds, videoMeta.videoCodec, videoMeta.audioCodec, videoMeta.fps, videoMeta.bitrate,
+		videoMeta.width, videoMeta.height, id)
+	if err != nil {
+		log.Printf("Failed to store video metadata for %d: %v", id, err)
+	}
+	return nil
 }
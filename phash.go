@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+const phashSize = 8
+
+// computePHash computes a perceptual hash for an image: an 8x8 DCT of a
+// greyscale-resized copy, thresholded against the median coefficient and
+// packed one bit per coefficient into a uint64. Unlike a cryptographic hash,
+// the Hamming distance between two pHashes tracks visual similarity, so
+// re-encodes and minor edits of the same meme land close together.
+func computePHash(src image.Image) uint64 {
+	small := imaging.Resize(src, phashSize, phashSize, imaging.Lanczos)
+
+	grey := make([][]float64, phashSize)
+	for y := 0; y < phashSize; y++ {
+		grey[y] = make([]float64, phashSize)
+		for x := 0; x < phashSize; x++ {
+			r, g, b, _ := small.At(x, y).RGBA()
+			grey[y][x] = 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8)
+		}
+	}
+
+	coeffs := flatten(dct2D(grey))
+	median := medianOf(coeffs)
+
+	var hash uint64
+	for i, v := range coeffs {
+		if v > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// hammingDistance64 counts the differing bits between two pHashes.
+func hammingDistance64(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// dct2D applies a 2D DCT-II to an NxN grid via separable 1D DCTs (rows then columns).
+func dct2D(in [][]float64) [][]float64 {
+	n := len(in)
+	rows := make([][]float64, n)
+	for y := 0; y < n; y++ {
+		rows[y] = dct1D(in[y])
+	}
+
+	out := make([][]float64, n)
+	for y := 0; y < n; y++ {
+		out[y] = make([]float64, n)
+	}
+	for x := 0; x < n; x++ {
+		col := make([]float64, n)
+		for y := 0; y < n; y++ {
+			col[y] = rows[y][x]
+		}
+		col = dct1D(col)
+		for y := 0; y < n; y++ {
+			out[y][x] = col[y]
+		}
+	}
+	return out
+}
+
+// dct1D computes the 1D DCT-II of a vector directly; N is always phashSize here.
+func dct1D(in []float64) []float64 {
+	n := len(in)
+	out := make([]float64, n)
+	for k := 0; k < n; k++ {
+		var sum float64
+		for i, v := range in {
+			sum += v * math.Cos(math.Pi/float64(n)*(float64(i)+0.5)*float64(k))
+		}
+		out[k] = sum
+	}
+	return out
+}
+
+func flatten(grid [][]float64) []float64 {
+	flat := make([]float64, 0, len(grid)*len(grid))
+	for _, row := range grid {
+		flat = append(flat, row...)
+	}
+	return flat
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
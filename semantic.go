@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clipSidecarCmdEnv names the env var holding the executable used to encode
+// images and text into CLIP embeddings (defaultClipSidecarCmd when unset),
+// similar to how tesseract/ffmpeg are invoked elsewhere. The executable must
+// support two subcommands: "embed-image <path>" and "embed-text <text>",
+// each printing a JSON array of float32s to stdout.
+const clipSidecarCmdEnv = "CLIP_SIDECAR_CMD"
+
+const defaultClipSidecarCmd = "clip-sidecar"
+
+// rrfK is the rank-fusion constant (score = sum 1/(k+rank)) shared by both
+// ranked lists being merged.
+const rrfK = 60
+
+// minSemanticSimilarity is the cosine similarity floor a CLIP match must
+// clear to count as a semantic hit. Without it, TopK always returns its k
+// nearest embeddings regardless of how unrelated they are, so an
+// off-vocabulary query that FTS legitimately matches zero rows for would
+// still fuse in up to k loosely-related results instead of returning none.
+const minSemanticSimilarity = 0.2
+
+// EmbeddingIndex is an in-memory, brute-force nearest-neighbour index over
+// media embeddings. Embeddings are small (512-768 float32s) so a linear scan
+// is fine; it's loaded lazily on startup and kept in sync as uploads finish
+// processing.
+type EmbeddingIndex struct {
+	mu      sync.RWMutex
+	vectors map[int64][]float32
+}
+
+var embeddingIndex = &EmbeddingIndex{vectors: make(map[int64][]float32)}
+
+// Set stores or replaces the embedding for a media id.
+func (idx *EmbeddingIndex) Set(id int64, v []float32) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.vectors[id] = v
+}
+
+// TopK returns up to k media ids ranked by cosine similarity to query, best
+// first, excluding any below minSemanticSimilarity.
+func (idx *EmbeddingIndex) TopK(query []float32, k int) []int64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	type scored struct {
+		id    int64
+		score float64
+	}
+	scores := make([]scored, 0, len(idx.vectors))
+	for id, v := range idx.vectors {
+		scores = append(scores, scored{id, cosineSimilarity(query, v)})
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].score > scores[j].score })
+
+	ids := make([]int64, 0, k)
+	for _, s := range scores {
+		if len(ids) == k {
+			break
+		}
+		if s.score < minSemanticSimilarity {
+			break
+		}
+		ids = append(ids, s.id)
+	}
+	return ids
+}
+
+// loadEmbeddingIndex populates the in-memory index from media_embeddings on startup.
+func loadEmbeddingIndex() {
+	rows, err := DB.Query("SELECT media_id, embedding FROM media_embeddings")
+	if err != nil {
+		log.Printf("Failed to load embedding index: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var id int64
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			log.Printf("Failed to scan embedding row: %v", err)
+			continue
+		}
+		embeddingIndex.Set(id, bytesToFloat32s(blob))
+		count++
+	}
+	log.Printf("Loaded %d embeddings into semantic search index", count)
+}
+
+// storeEmbedding persists an embedding for mediaID and updates the in-memory index.
+func storeEmbedding(mediaID int64, vec []float32) {
+	_, err := DB.Exec("INSERT INTO media_embeddings (media_id, embedding) VALUES (?, ?) ON CONFLICT(media_id) DO UPDATE SET embedding = excluded.embedding",
+		mediaID, float32sToBytes(vec))
+	if err != nil {
+		log.Printf("Failed to store embedding for %d: %v", mediaID, err)
+		return
+	}
+	embeddingIndex.Set(mediaID, vec)
+}
+
+// encodeImageEmbedding runs the CLIP sidecar over an image (or video frame) and
+// returns its embedding vector.
+func encodeImageEmbedding(imagePath string) ([]float32, error) {
+	return runClipSidecar("embed-image", imagePath)
+}
+
+// textEmbeddingCache avoids re-encoding repeated search queries against the sidecar.
+var (
+	textEmbeddingCacheMu sync.Mutex
+	textEmbeddingCache   = make(map[string][]float32)
+)
+
+// encodeTextEmbedding encodes a search query with the same CLIP model used for images.
+func encodeTextEmbedding(text string) ([]float32, error) {
+	textEmbeddingCacheMu.Lock()
+	if cached, ok := textEmbeddingCache[text]; ok {
+		textEmbeddingCacheMu.Unlock()
+		return cached, nil
+	}
+	textEmbeddingCacheMu.Unlock()
+
+	vec, err := runClipSidecar("embed-text", text)
+	if err != nil {
+		return nil, err
+	}
+
+	textEmbeddingCacheMu.Lock()
+	textEmbeddingCache[text] = vec
+	textEmbeddingCacheMu.Unlock()
+	return vec, nil
+}
+
+func runClipSidecar(subcommand, arg string) ([]float32, error) {
+	binPath := os.Getenv(clipSidecarCmdEnv)
+	if binPath == "" {
+		binPath = defaultClipSidecarCmd
+	}
+
+	cmd := exec.Command(binPath, subcommand, arg)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	var vec []float32
+	if err := json.Unmarshal(out.Bytes(), &vec); err != nil {
+		return nil, err
+	}
+	return vec, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func float32sToBytes(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func bytesToFloat32s(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return v
+}
+
+// reciprocalRankFusion merges multiple ranked id lists into one, scoring each
+// id by sum(1/(k+rank)) across the lists it appears in, rank starting at 1.
+func reciprocalRankFusion(rankings ...[]int64) []int64 {
+	scores := make(map[int64]float64)
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			scores[id] += 1.0 / float64(rrfK+rank+1)
+		}
+	}
+
+	ids := make([]int64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return scores[ids[i]] > scores[ids[j]] })
+	return ids
+}
+
+// semanticSearchHandler encodes the query text with the CLIP model and returns
+// media ranked by cosine similarity against the in-memory embedding index.
+func semanticSearchHandler(c *gin.Context) {
+	q := c.Query("q")
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing q"})
+		return
+	}
+
+	queryVec, err := encodeTextEmbedding(q)
+	if err != nil {
+		log.Printf("Semantic search encode failed for %q: %v", q, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := embeddingIndex.TopK(queryVec, 50)
+	media, err := getMediaByIDs(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, media)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+)
+
+// Storage abstracts where media bytes live so the rest of the app doesn't
+// care whether a key is a local file or an S3/MinIO object. Keys are the
+// same relative paths media.path and media.thumb have always stored, e.g.
+// "storage/ab/abcdef....jpg" or "static/123_thumb.jpg".
+type Storage interface {
+	// Put writes r to key, (over)writing any existing object.
+	Put(ctx context.Context, key string, r io.Reader) (url string, err error)
+	// Get opens key for reading; the caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key.
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL clients can use to fetch key directly.
+	SignedURL(ctx context.Context, key string) (string, error)
+}
+
+// store is the process-wide Storage backend, selected by initStorage.
+var store Storage
+
+// initStorage selects the Storage backend from the STORAGE_BACKEND env var
+// ("local", the default, or "s3").
+func initStorage() {
+	if os.Getenv("STORAGE_BACKEND") == "s3" {
+		s3store, err := newS3StorageFromEnv(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to init S3 storage: %v", err)
+		}
+		store = s3store
+		log.Println("Using S3 storage backend")
+		return
+	}
+	store = NewLocalFS(".")
+	log.Println("Using local filesystem storage backend")
+}
+
+// writeTempFile writes data to a local OS temp file (with the given
+// extension) for tools that need a real path rather than storage bytes. The
+// returned cleanup func removes it.
+func writeTempFile(data []byte, ext string) (path string, cleanup func(), err error) {
+	tmp, err := os.CreateTemp("", "meme-vault-*"+ext)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
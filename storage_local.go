@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalFS stores media on local disk rooted at baseDir, the behavior
+// meme-vault has always had. Keys map 1:1 onto filepath.Join(baseDir, key),
+// and SignedURL just returns the "/"+key path served by the r.Static mounts.
+type LocalFS struct {
+	baseDir string
+}
+
+func NewLocalFS(baseDir string) *LocalFS {
+	return &LocalFS{baseDir: baseDir}
+}
+
+func (l *LocalFS) Put(ctx context.Context, key string, r io.Reader) (string, error) {
+	path := filepath.Join(l.baseDir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return "", err
+	}
+	return l.SignedURL(ctx, key)
+}
+
+func (l *LocalFS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.baseDir, key))
+}
+
+func (l *LocalFS) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.Join(l.baseDir, key))
+}
+
+func (l *LocalFS) SignedURL(ctx context.Context, key string) (string, error) {
+	return "/" + key, nil
+}